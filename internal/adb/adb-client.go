@@ -3,11 +3,22 @@ package adb
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/TinkerUp/adb-server/internal/db"
 	"github.com/TinkerUp/adb-server/types/models"
+	"github.com/google/uuid"
 	adb "github.com/zach-klippenstein/goadb"
 )
 
@@ -15,28 +26,43 @@ type ADBClient interface {
 	Version(ctx context.Context) (int, error)
 
 	Devices(ctx context.Context) ([]models.Device, error)
-	TrackDeviceStates(ctx context.Context, deviceSerial string) (<-chan models.DeviceStateChange, error)
+	Subscribe(ctx context.Context, filter DeviceFilter) (<-chan models.DeviceStateChange, error)
 
 	Packages(ctx context.Context, deviceId string, opts models.ListPackageOptions) ([]models.Package, error)
 	Install(ctx context.Context, deviceId string, pkgPath string) error
 	Uninstall(ctx context.Context, deviceId string, pkgName string, keepData bool, user int) error
+	InstallFromStore(ctx context.Context, deviceId, owner, fileId string, opts models.InstallOptions) error
 
-	Pull(ctx context.Context, serial, remotePath, localPath string) error
-	Push(ctx context.Context, serial, localPath, remotePath string) error
+	Pull(ctx context.Context, serial, remotePath, localPath string, opts models.TransferOptions) (<-chan models.TransferEvent, error)
+	Push(ctx context.Context, serial, localPath, remotePath string, opts models.TransferOptions) (<-chan models.TransferEvent, error)
 }
 
+// transferProgressInterval is how often in-flight byte counts are reported
+// on a transfer's event channel, so a large single file still yields
+// periodic progress instead of one event at the end.
+const transferProgressInterval = 250 * time.Millisecond
+
+// dirPermsRelaxed matches db.DirPermsRelaxed for directories Pull creates
+// on the local filesystem to receive a pulled file.
+const dirPermsRelaxed os.FileMode = 0o755
+
 type GoADBClient struct {
-	adb *adb.Adb
+	adb   *adb.Adb
+	files db.FileService
+
+	eventsOnce sync.Once
+	events     *deviceEventBus
 }
 
-func NewGoADBClient() (*GoADBClient, error) {
+func NewGoADBClient(files db.FileService) (*GoADBClient, error) {
 	client, err := adb.New()
 	if err != nil {
 		return nil, err
 	}
 
 	return &GoADBClient{
-		adb: client,
+		adb:   client,
+		files: files,
 	}, nil
 }
 
@@ -125,42 +151,13 @@ func (client *GoADBClient) Devices(ctx context.Context) ([]models.Device, error)
 	}
 }
 
-func (client *GoADBClient) TrackDeviceStates(ctx context.Context, deviceSerial string) (<-chan models.DeviceStateChange, error) {
-	goAdbChannel := client.adb.NewDeviceWatcher().C()
-
-	stateChannel := make(chan models.DeviceStateChange)
-
-	go func() {
-		defer close(stateChannel)
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case watcher, ok := <-goAdbChannel:
-				if !ok {
-					return
-				}
-
-				if watcher.Serial == deviceSerial {
-					stateChange := models.DeviceStateChange{
-						Serial:    deviceSerial,
-						OldState:  client.convertState(watcher.OldState),
-						NewState:  client.convertState(watcher.NewState),
-						Timestamp: time.Now(),
-					}
-
-					select {
-					case stateChannel <- stateChange:
-					case <-ctx.Done():
-						return
-					}
-				}
-			}
-		}
-	}()
-
-	return stateChannel, nil
+// Subscribe returns a channel of device state changes matching filter. Every
+// subscriber gets its own channel fed by a single shared device-watcher
+// goroutine (see device-watcher.go), so one slow or narrowly-filtered
+// subscriber can no longer starve the others the way the old
+// TrackDeviceStates did.
+func (client *GoADBClient) Subscribe(ctx context.Context, filter DeviceFilter) (<-chan models.DeviceStateChange, error) {
+	return client.eventBus().subscribe(ctx, filter), nil
 }
 
 func (client *GoADBClient) packages(deviceId string, opts models.ListPackageOptions) ([]models.Package, error) {
@@ -241,6 +238,506 @@ func (client *GoADBClient) Packages(ctx context.Context, deviceId string, opts m
 	}
 }
 
+// progressCounter counts the bytes moving through a countingWriter or
+// countingReader and reports them on a transfer event channel at most every
+// transferProgressInterval, mirroring how filesync reports chunked progress.
+type progressCounter struct {
+	ctx         context.Context
+	file        string
+	total       int64
+	start       time.Time
+	lastReport  time.Time
+	transferred int64
+	events      chan<- models.TransferEvent
+}
+
+func newProgressCounter(ctx context.Context, file string, total int64, events chan<- models.TransferEvent) *progressCounter {
+	now := time.Now()
+	return &progressCounter{
+		ctx:        ctx,
+		file:       file,
+		total:      total,
+		start:      now,
+		lastReport: now,
+		events:     events,
+	}
+}
+
+// add reports n more bytes transferred, sending a progress event at most
+// every transferProgressInterval. Like every other event send in
+// pushFile/pullFile, it backs off via ctx.Done() instead of blocking forever
+// - copyWithCancel's caller may have stopped reading the events channel the
+// moment it cancelled ctx, and this runs inside the same io.Copy that
+// cancellation is supposed to unblock.
+func (p *progressCounter) add(n int) {
+	p.transferred += int64(n)
+
+	now := time.Now()
+	if now.Sub(p.lastReport) < transferProgressInterval && p.transferred < p.total {
+		return
+	}
+	p.lastReport = now
+
+	var eta time.Duration
+	if elapsed := now.Sub(p.start); elapsed > 0 && p.transferred > 0 {
+		rate := float64(p.transferred) / elapsed.Seconds()
+		if rate > 0 {
+			eta = time.Duration(float64(p.total-p.transferred)/rate) * time.Second
+		}
+	}
+
+	select {
+	case p.events <- models.TransferEvent{
+		Type:             models.TransferEventProgress,
+		File:             p.file,
+		BytesTransferred: p.transferred,
+		TotalBytes:       p.total,
+		ETA:              eta,
+	}:
+	case <-p.ctx.Done():
+	}
+}
+
+type countingWriter struct {
+	w        io.Writer
+	progress *progressCounter
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.progress.add(n)
+	return n, err
+}
+
+type countingReader struct {
+	r        io.Reader
+	progress *progressCounter
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.progress.add(n)
+	return n, err
+}
+
+func (client *GoADBClient) Push(ctx context.Context, serial, localPath, remotePath string, opts models.TransferOptions) (<-chan models.TransferEvent, error) {
+	device := client.getDevice(serial)
+	if device == nil {
+		return nil, errors.New("device not found")
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat local path: %w", err)
+	}
+
+	if info.IsDir() && !opts.Recursive {
+		return nil, fmt.Errorf("%s is a directory, use TransferOptions.Recursive to push it", localPath)
+	}
+
+	events := make(chan models.TransferEvent)
+
+	go func() {
+		defer close(events)
+
+		if info.IsDir() {
+			err = filepath.Walk(localPath, func(path string, walkInfo os.FileInfo, walkErr error) error {
+				if walkErr != nil {
+					return walkErr
+				}
+				if walkInfo.IsDir() {
+					return nil
+				}
+
+				rel, relErr := filepath.Rel(localPath, path)
+				if relErr != nil {
+					return relErr
+				}
+
+				return client.pushFile(ctx, device, path, filepath.ToSlash(filepath.Join(remotePath, rel)), walkInfo, opts, events)
+			})
+		} else {
+			err = client.pushFile(ctx, device, localPath, remotePath, info, opts, events)
+		}
+
+		if err != nil {
+			select {
+			case events <- models.TransferEvent{Type: models.TransferEventComplete, Err: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		select {
+		case events <- models.TransferEvent{Type: models.TransferEventComplete}:
+		case <-ctx.Done():
+		}
+	}()
+
+	return events, nil
+}
+
+func (client *GoADBClient) pushFile(ctx context.Context, device *adb.Device, localPath, remotePath string, info os.FileInfo, opts models.TransferOptions, events chan<- models.TransferEvent) error {
+	select {
+	case events <- models.TransferEvent{Type: models.TransferEventFileStarted, File: remotePath, TotalBytes: info.Size()}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer src.Close()
+
+	dst, err := device.OpenWrite(remotePath, info.Mode().Perm(), info.ModTime())
+	if err != nil {
+		return fmt.Errorf("failed to open %s on device: %w", remotePath, err)
+	}
+	defer dst.Close()
+
+	progress := newProgressCounter(ctx, remotePath, info.Size(), events)
+
+	if err := copyWithCancel(ctx, countingWriter{dst, progress}, src, dst, src); err != nil {
+		return fmt.Errorf("failed to push %s: %w", remotePath, err)
+	}
+
+	if opts.VerifyChecksum {
+		if err := client.verifyRemoteChecksum(device, localPath, remotePath); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case events <- models.TransferEvent{Type: models.TransferEventFileDone, File: remotePath, BytesTransferred: info.Size(), TotalBytes: info.Size()}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+func (client *GoADBClient) Pull(ctx context.Context, serial, remotePath, localPath string, opts models.TransferOptions) (<-chan models.TransferEvent, error) {
+	device := client.getDevice(serial)
+	if device == nil {
+		return nil, errors.New("device not found")
+	}
+
+	events := make(chan models.TransferEvent)
+
+	go func() {
+		defer close(events)
+
+		err := client.pullFile(ctx, device, remotePath, localPath, opts, events)
+
+		if err != nil {
+			select {
+			case events <- models.TransferEvent{Type: models.TransferEventComplete, Err: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		select {
+		case events <- models.TransferEvent{Type: models.TransferEventComplete}:
+		case <-ctx.Done():
+		}
+	}()
+
+	return events, nil
+}
+
+func (client *GoADBClient) pullFile(ctx context.Context, device *adb.Device, remotePath, localPath string, opts models.TransferOptions, events chan<- models.TransferEvent) error {
+	entry, err := device.Stat(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s on device: %w", remotePath, err)
+	}
+
+	select {
+	case events <- models.TransferEvent{Type: models.TransferEventFileStarted, File: remotePath, TotalBytes: int64(entry.Size)}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	src, err := device.OpenRead(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s on device: %w", remotePath, err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), dirPermsRelaxed); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", localPath, err)
+	}
+	defer dst.Close()
+
+	progress := newProgressCounter(ctx, remotePath, int64(entry.Size), events)
+
+	if err := copyWithCancel(ctx, dst, countingReader{src, progress}, dst, src); err != nil {
+		return fmt.Errorf("failed to pull %s: %w", remotePath, err)
+	}
+
+	if opts.VerifyChecksum {
+		if err := client.verifyRemoteChecksum(device, localPath, remotePath); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case events <- models.TransferEvent{Type: models.TransferEventFileDone, File: remotePath, BytesTransferred: int64(entry.Size), TotalBytes: int64(entry.Size)}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// verifyRemoteChecksum recomputes the SHA-256 of remotePath on the device via
+// `sha256sum` and compares it against the local file, catching corruption
+// introduced in transit that a byte-count match alone would miss.
+func (client *GoADBClient) verifyRemoteChecksum(device *adb.Device, localPath, remotePath string) error {
+	if err := validateShellToken(remotePath); err != nil {
+		return fmt.Errorf("invalid remote path for checksum verification: %w", err)
+	}
+
+	localData, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s for checksum verification: %w", localPath, err)
+	}
+	localSum := sha256.Sum256(localData)
+	localChecksum := hex.EncodeToString(localSum[:])
+
+	out, err := device.RunCommand("sha256sum", remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to compute checksum on device: %w", err)
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return fmt.Errorf("unexpected sha256sum output for %s: %q", remotePath, out)
+	}
+	remoteChecksum := fields[0]
+
+	if !strings.EqualFold(localChecksum, remoteChecksum) {
+		return fmt.Errorf("checksum mismatch for %s: local %s, device %s", remotePath, localChecksum, remoteChecksum)
+	}
+
+	return nil
+}
+
+// copyWithCancel behaves like io.Copy but aborts as soon as ctx is done, so a
+// cancelled Push/Pull doesn't keep streaming to a caller who left. Cancelling
+// the context alone can't interrupt a blocked io.Copy, so on ctx.Done it
+// closes closers (the real underlying connection/file handles, not the
+// counting wrappers passed as dst/src) to unblock the in-flight Read/Write,
+// then waits for the copy goroutine to actually exit before returning.
+func copyWithCancel(ctx context.Context, dst io.Writer, src io.Reader, closers ...io.Closer) error {
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := io.Copy(dst, src)
+		done <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		for _, closer := range closers {
+			closer.Close()
+		}
+		<-done
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+// validShellToken matches the characters goadb's shell wrapper only quotes
+// around whitespace and otherwise joins verbatim into the device's `shell:`
+// request - anything outside this set (`;`, backticks, `$()`, ...) would be
+// interpreted by the device's sh instead of being treated as a literal
+// package name or path. Used to sanitize every value we pass to
+// device.RunCommand, not just package names: a device path is just as
+// attacker-reachable through Push/Pull/InstallFromStore.
+var validShellToken = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+func validateShellToken(token string) error {
+	if !validShellToken.MatchString(token) {
+		return fmt.Errorf("invalid device path or name: %q", token)
+	}
+	return nil
+}
+
+func (client *GoADBClient) installArgs(pkgPath string, opts models.InstallOptions) []string {
+	args := make([]string, 0, 5)
+
+	if opts.Replace {
+		args = append(args, "-r")
+	}
+	if opts.AllowDowngrade {
+		args = append(args, "-d")
+	}
+	if opts.GrantPermissions {
+		args = append(args, "-g")
+	}
+	if opts.User != nil {
+		args = append(args, "--user", strconv.Itoa(*opts.User))
+	}
+
+	return append(args, pkgPath)
+}
+
+func (client *GoADBClient) install(deviceId, pkgPath string, opts models.InstallOptions) error {
+	if err := validateShellToken(pkgPath); err != nil {
+		return err
+	}
+
+	device := client.getDevice(deviceId)
+	if device == nil {
+		return errors.New("device not found")
+	}
+
+	out, err := device.RunCommand("pm install", client.installArgs(pkgPath, opts)...)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(out, "Success") {
+		return fmt.Errorf("pm install failed: %s", strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// Install installs an APK already present at pkgPath on the device,
+// replacing any existing installation. Use InstallFromStore to push and
+// install an APK held by FileService in one call.
+func (client *GoADBClient) Install(ctx context.Context, deviceId string, pkgPath string) error {
+	errCh := make(chan error)
+
+	go func() {
+		errCh <- client.install(deviceId, pkgPath, models.InstallOptions{Replace: true})
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (client *GoADBClient) uninstall(deviceId, pkgName string, keepData bool, user int) error {
+	if err := validateShellToken(pkgName); err != nil {
+		return err
+	}
+
+	device := client.getDevice(deviceId)
+	if device == nil {
+		return errors.New("device not found")
+	}
+
+	args := make([]string, 0, 4)
+
+	if keepData {
+		args = append(args, "-k")
+	}
+	if user >= 0 {
+		args = append(args, "--user", strconv.Itoa(user))
+	}
+	args = append(args, pkgName)
+
+	out, err := device.RunCommand("pm uninstall", args...)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(out, "Success") {
+		return fmt.Errorf("pm uninstall failed: %s", strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+func (client *GoADBClient) Uninstall(ctx context.Context, deviceId string, pkgName string, keepData bool, user int) error {
+	errCh := make(chan error)
+
+	go func() {
+		errCh <- client.uninstall(deviceId, pkgName, keepData, user)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// InstallFromStore resolves fileId through FileService, verifies its
+// checksum, pushes the blob straight from FileService's content-addressed
+// path to a scratch location under /data/local/tmp, and installs it with
+// pm. The scratch file is removed from the device whether or not the
+// install succeeds.
+func (client *GoADBClient) InstallFromStore(ctx context.Context, deviceId, owner, fileId string, opts models.InstallOptions) error {
+	if client.files == nil {
+		return errors.New("file service not configured")
+	}
+
+	// No passphrase support here yet: InstallFromStore can only resolve
+	// files stored with FileService.SecureMode off.
+	file, err := client.files.GetFile(ctx, owner, nil, fileId, "")
+	if err != nil {
+		return fmt.Errorf("failed to resolve file %s: %w", fileId, err)
+	}
+
+	sum := sha256.Sum256(file.Data)
+	if hex.EncodeToString(sum[:]) != file.Metadata.Checksum {
+		return fmt.Errorf("checksum mismatch for file %s", fileId)
+	}
+
+	remotePath := fmt.Sprintf("/data/local/tmp/%s.apk", uuid.NewString())
+
+	events, err := client.Push(ctx, deviceId, file.Metadata.FilePath, remotePath, models.TransferOptions{VerifyChecksum: true})
+	if err != nil {
+		return fmt.Errorf("failed to push %s to device: %w", fileId, err)
+	}
+
+	for event := range events {
+		if event.Type == models.TransferEventComplete && event.Err != nil {
+			return fmt.Errorf("failed to push %s to device: %w", fileId, event.Err)
+		}
+	}
+
+	installErr := client.install(deviceId, remotePath, opts)
+	cleanupErr := client.removeRemoteFile(deviceId, remotePath)
+
+	if installErr != nil && cleanupErr != nil {
+		return errors.Join(fmt.Errorf("pm install failed: %w", installErr), fmt.Errorf("cleanup failed: %w", cleanupErr))
+	}
+	if installErr != nil {
+		return installErr
+	}
+	if cleanupErr != nil {
+		return fmt.Errorf("cleanup failed: %w", cleanupErr)
+	}
+
+	return nil
+}
+
+func (client *GoADBClient) removeRemoteFile(deviceId, remotePath string) error {
+	device := client.getDevice(deviceId)
+	if device == nil {
+		return errors.New("device not found")
+	}
+
+	_, err := device.RunCommand("rm", "-f", remotePath)
+	return err
+}
+
 func (client *GoADBClient) getDevice(serial string) *adb.Device {
 	return client.adb.Device(adb.DeviceWithSerial(serial))
 }
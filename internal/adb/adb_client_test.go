@@ -0,0 +1,29 @@
+package adb
+
+import "testing"
+
+func TestValidateShellToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "package name", token: "com.example.app", wantErr: false},
+		{name: "apk path", token: "/data/app/com.example.app-1/base.apk", wantErr: false},
+		{name: "generated scratch path", token: "/data/local/tmp/3c9e6c2e-df5c-4b2a-9b3b-5e2c3b6b8e0a.apk", wantErr: false},
+		{name: "semicolon command chaining", token: "com.example.app;reboot", wantErr: true},
+		{name: "backtick command substitution", token: "`reboot`", wantErr: true},
+		{name: "dollar command substitution", token: "$(reboot)", wantErr: true},
+		{name: "embedded whitespace", token: "com.example.app reboot", wantErr: true},
+		{name: "empty token", token: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateShellToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateShellToken(%q) error = %v, wantErr %v", tt.token, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package adb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TinkerUp/adb-server/types/models"
+)
+
+const (
+	// subscriberBufferSize lets a subscriber fall behind briefly without
+	// blocking the fan-out goroutine; once full, new events for it are
+	// dropped rather than stalling every other subscriber.
+	subscriberBufferSize = 32
+
+	bootPollInterval    = 2 * time.Second
+	bootPollMaxAttempts = 30
+)
+
+// DeviceFilter decides which DeviceStateChange events a Subscribe call
+// receives. Build one with AllDevices, DevicesWithSerial, or
+// DevicesInState.
+type DeviceFilter struct {
+	match func(models.DeviceStateChange) bool
+}
+
+// AllDevices matches every device state change.
+func AllDevices() DeviceFilter {
+	return DeviceFilter{match: func(models.DeviceStateChange) bool { return true }}
+}
+
+// DevicesWithSerial matches changes for any of the given serials.
+func DevicesWithSerial(serials ...string) DeviceFilter {
+	set := make(map[string]struct{}, len(serials))
+	for _, serial := range serials {
+		set[serial] = struct{}{}
+	}
+
+	return DeviceFilter{match: func(change models.DeviceStateChange) bool {
+		_, ok := set[change.Serial]
+		return ok
+	}}
+}
+
+// DevicesInState matches changes whose new state satisfies predicate.
+func DevicesInState(predicate func(models.DeviceState) bool) DeviceFilter {
+	return DeviceFilter{match: func(change models.DeviceStateChange) bool {
+		return predicate(change.NewState)
+	}}
+}
+
+type subscriber struct {
+	filter DeviceFilter
+	ch     chan models.DeviceStateChange
+}
+
+// deviceEventBus fans a single goadb device watcher out to any number of
+// subscribers, each with its own filter and buffered channel, so no
+// subscriber's events are silently dropped because another subscriber only
+// cared about one serial.
+type deviceEventBus struct {
+	client *GoADBClient
+
+	mu          sync.Mutex
+	subscribers map[int]*subscriber
+	nextID      int
+}
+
+func newDeviceEventBus(client *GoADBClient) *deviceEventBus {
+	return &deviceEventBus{
+		client:      client,
+		subscribers: make(map[int]*subscriber),
+	}
+}
+
+// eventBus lazily starts the shared watcher goroutine on first use, so a
+// GoADBClient that never subscribes never opens a device watcher.
+func (client *GoADBClient) eventBus() *deviceEventBus {
+	client.eventsOnce.Do(func() {
+		client.events = newDeviceEventBus(client)
+		go client.events.run()
+	})
+
+	return client.events
+}
+
+func (bus *deviceEventBus) run() {
+	watcherCh := bus.client.adb.NewDeviceWatcher().C()
+
+	for watcher := range watcherCh {
+		oldState := bus.client.convertState(watcher.OldState)
+		newState := bus.client.convertState(watcher.NewState)
+
+		bus.publish(models.DeviceStateChange{
+			Serial:    watcher.Serial,
+			OldState:  oldState,
+			NewState:  newState,
+			Timestamp: time.Now(),
+		})
+
+		if newState == models.DeviceStateOnline && oldState != models.DeviceStateOnline {
+			go bus.emitBootingTransition(watcher.Serial)
+		}
+	}
+
+	bus.closeAll()
+}
+
+func (bus *deviceEventBus) subscribe(ctx context.Context, filter DeviceFilter) <-chan models.DeviceStateChange {
+	ch := make(chan models.DeviceStateChange, subscriberBufferSize)
+
+	bus.mu.Lock()
+	id := bus.nextID
+	bus.nextID++
+	bus.subscribers[id] = &subscriber{filter: filter, ch: ch}
+	bus.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		bus.unsubscribe(id)
+	}()
+
+	return ch
+}
+
+func (bus *deviceEventBus) unsubscribe(id int) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if sub, ok := bus.subscribers[id]; ok {
+		delete(bus.subscribers, id)
+		close(sub.ch)
+	}
+}
+
+func (bus *deviceEventBus) publish(change models.DeviceStateChange) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for _, sub := range bus.subscribers {
+		if !sub.filter.match(change) {
+			continue
+		}
+
+		select {
+		case sub.ch <- change:
+		default: // subscriber's buffer is full; drop rather than block the fan-out
+		}
+	}
+}
+
+func (bus *deviceEventBus) closeAll() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for id, sub := range bus.subscribers {
+		close(sub.ch)
+		delete(bus.subscribers, id)
+	}
+}
+
+// emitBootingTransition polls sys.boot_completed after a device comes
+// online and, if it isn't set yet, synthesizes an online->booting->online
+// pair of transitions so subscribers see an accurate lifecycle instead of
+// the device looking online while still finishing its boot.
+func (bus *deviceEventBus) emitBootingTransition(serial string) {
+	device := bus.client.getDevice(serial)
+	if device == nil {
+		return
+	}
+
+	bootCompleted := func() bool {
+		out, err := device.RunCommand("getprop", "sys.boot_completed")
+		return err == nil && strings.TrimSpace(out) == "1"
+	}
+
+	if bootCompleted() {
+		return
+	}
+
+	bus.publish(models.DeviceStateChange{
+		Serial:    serial,
+		OldState:  models.DeviceStateOnline,
+		NewState:  models.DeviceStateBooting,
+		Timestamp: time.Now(),
+	})
+
+	ticker := time.NewTicker(bootPollInterval)
+	defer ticker.Stop()
+
+	for i := 0; i < bootPollMaxAttempts; i++ {
+		<-ticker.C
+
+		if bootCompleted() {
+			bus.publish(models.DeviceStateChange{
+				Serial:    serial,
+				OldState:  models.DeviceStateBooting,
+				NewState:  models.DeviceStateOnline,
+				Timestamp: time.Now(),
+			})
+			return
+		}
+	}
+
+	// bootPollMaxAttempts was exhausted without ever seeing
+	// sys.boot_completed. Correct the synthetic "booting" we published above
+	// rather than leaving every subscriber believing the device is still
+	// mid-boot forever: re-check the device's real adb state and publish
+	// whatever that is.
+	state, err := device.State()
+	newState := models.DeviceStateOffline
+	if err == nil {
+		newState = bus.client.convertState(state)
+	}
+
+	bus.publish(models.DeviceStateChange{
+		Serial:    serial,
+		OldState:  models.DeviceStateBooting,
+		NewState:  newState,
+		Timestamp: time.Now(),
+	})
+}
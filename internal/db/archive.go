@@ -0,0 +1,296 @@
+package db
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/TinkerUp/adb-server/types/models"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// maxArchiveEntries and maxArchiveExtractedBytes bound how much a single
+// SaveArchive call can expand, so a crafted zip/gzip/xz/zstd bomb can't
+// exhaust memory decompressing entries we read fully into RAM.
+const (
+	maxArchiveEntries        = 10_000
+	maxArchiveExtractedBytes = 1 << 30 // 1 GiB, aggregate across all entries
+)
+
+// archiveLimiter tracks entry count and aggregate decompressed size across a
+// single extractZipEntries/extractTarEntries call, so callers can reject an
+// oversized or overly-fragmented archive before io.ReadAll-ing it into
+// memory.
+type archiveLimiter struct {
+	entries int
+	bytes   int64
+}
+
+func (l *archiveLimiter) addEntry(declaredSize int64) error {
+	l.entries++
+	if l.entries > maxArchiveEntries {
+		return fmt.Errorf("archive has too many entries (max %d)", maxArchiveEntries)
+	}
+
+	l.bytes += declaredSize
+	if l.bytes > maxArchiveExtractedBytes {
+		return fmt.Errorf("archive exceeds max decompressed size (%d bytes)", maxArchiveExtractedBytes)
+	}
+
+	return nil
+}
+
+type archiveFormat string
+
+const (
+	archiveFormatZip  archiveFormat = "zip"
+	archiveFormatGzip archiveFormat = "tar.gz"
+	archiveFormatXz   archiveFormat = "tar.xz"
+	archiveFormatZstd archiveFormat = "tar.zst"
+	archiveFormatTar  archiveFormat = "tar"
+)
+
+// archiveEntry is a single extracted file, ready to be handed to persistFile.
+type archiveEntry struct {
+	Name string
+	Data []byte
+}
+
+// detectArchiveFormat identifies an archive by its magic bytes rather than
+// any filename extension, since uploads are handed to us as raw bytes.
+func detectArchiveFormat(data []byte) (archiveFormat, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{'P', 'K', 0x03, 0x04}):
+		return archiveFormatZip, nil
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		return archiveFormatGzip, nil
+	case bytes.HasPrefix(data, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}):
+		return archiveFormatXz, nil
+	case bytes.HasPrefix(data, []byte{0x28, 0xB5, 0x2F, 0xFD}):
+		return archiveFormatZstd, nil
+	case len(data) > 262 && string(data[257:262]) == "ustar":
+		return archiveFormatTar, nil
+	default:
+		return "", fmt.Errorf("unrecognized archive format")
+	}
+}
+
+// SaveArchive detects the archive format of archiveData by magic bytes,
+// expands it, stores each extracted file through persistFile the same way
+// SaveFile does, and registers one FileIndex row per extracted entry, all
+// as a single transaction.
+func (s *fileService) SaveArchive(owner string, accessGroups []string, archiveData []byte, passphrase string) ([]models.FileIndex, error) {
+	format, err := detectArchiveFormat(archiveData)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := extractArchiveEntries(format, archiveData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileIndexes := make([]models.FileIndex, 0, len(entries))
+
+	for _, entry := range entries {
+		if err := validateExtractedPath(entry.Name); err != nil {
+			return nil, err
+		}
+
+		fileIndex, err := s.persistFile(tx, owner, accessGroups, entry.Name, entry.Data, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to persist %s: %w", entry.Name, err)
+		}
+
+		fileIndexes = append(fileIndexes, fileIndex)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return fileIndexes, nil
+}
+
+// validateExtractedPath rejects the entry names that make zip-slip possible:
+// absolute paths, `..` traversal, and anything that escapes the sandbox once
+// cleaned.
+func validateExtractedPath(name string) error {
+	if filepath.IsAbs(name) {
+		return fmt.Errorf("archive entry has an absolute path: %s", name)
+	}
+
+	cleaned := filepath.Clean(name)
+
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("archive entry escapes sandbox: %s", name)
+	}
+
+	return nil
+}
+
+func extractArchiveEntries(format archiveFormat, data []byte) ([]archiveEntry, error) {
+	switch format {
+	case archiveFormatZip:
+		return extractZipEntries(data)
+	case archiveFormatGzip:
+		gzReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gzReader.Close()
+		return extractTarEntries(gzReader)
+	case archiveFormatXz:
+		xzReader, err := xz.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open xz stream: %w", err)
+		}
+		return extractTarEntries(xzReader)
+	case archiveFormatZstd:
+		zstdReader, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		defer zstdReader.Close()
+		return extractTarEntries(zstdReader)
+	case archiveFormatTar:
+		return extractTarEntries(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+func extractZipEntries(data []byte) ([]archiveEntry, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+
+	var entries []archiveEntry
+	var limiter archiveLimiter
+
+	for _, zipFile := range reader.File {
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+
+		if err := limiter.addEntry(int64(zipFile.UncompressedSize64)); err != nil {
+			return nil, err
+		}
+
+		if zipFile.Mode()&fs.ModeSymlink != 0 {
+			// Unlike tar, archive/zip has no Linkname field - a symlink's
+			// target is stored as the entry's (uncompressed) content, so it
+			// has to be read to validate it; passing an empty target here
+			// would make the escape check a no-op.
+			fileReader, err := zipFile.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open symlink %s in zip archive: %w", zipFile.Name, err)
+			}
+
+			target, err := io.ReadAll(fileReader)
+			fileReader.Close()
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read symlink target for %s in zip archive: %w", zipFile.Name, err)
+			}
+
+			if err := validateSymlinkTarget(zipFile.Name, string(target)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fileReader, err := zipFile.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s in zip archive: %w", zipFile.Name, err)
+		}
+
+		fileData, err := io.ReadAll(fileReader)
+		fileReader.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from zip archive: %w", zipFile.Name, err)
+		}
+
+		entries = append(entries, archiveEntry{Name: zipFile.Name, Data: fileData})
+	}
+
+	return entries, nil
+}
+
+func extractTarEntries(reader io.Reader) ([]archiveEntry, error) {
+	tarReader := tar.NewReader(reader)
+
+	var entries []archiveEntry
+	var limiter archiveLimiter
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeSymlink:
+			if err := validateSymlinkTarget(header.Name, header.Linkname); err != nil {
+				return nil, err
+			}
+			continue
+		case tar.TypeReg:
+			if err := limiter.addEntry(header.Size); err != nil {
+				return nil, err
+			}
+
+			fileData, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s from tar archive: %w", header.Name, err)
+			}
+			entries = append(entries, archiveEntry{Name: header.Name, Data: fileData})
+		}
+	}
+
+	return entries, nil
+}
+
+// validateSymlinkTarget rejects symlinks whose target would resolve outside
+// the sandbox. We never materialize the symlink itself (a File index has no
+// notion of one) - this only guards against zip-slip via a link target.
+func validateSymlinkTarget(name, target string) error {
+	if err := validateExtractedPath(name); err != nil {
+		return err
+	}
+
+	if target == "" {
+		return nil
+	}
+
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("symlink %s points to an absolute path: %s", name, target)
+	}
+
+	resolved := filepath.Clean(filepath.Join(filepath.Dir(name), target))
+	if resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("symlink %s escapes sandbox: %s", name, target)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import "testing"
+
+func TestValidateExtractedPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		wantErr bool
+	}{
+		{name: "plain file", entry: "apk/app.apk", wantErr: false},
+		{name: "nested file", entry: "a/b/c.txt", wantErr: false},
+		{name: "absolute path", entry: "/etc/passwd", wantErr: true},
+		{name: "parent traversal", entry: "../../etc/passwd", wantErr: true},
+		{name: "parent traversal mid-path", entry: "a/../../b", wantErr: true},
+		{name: "dot only", entry: ".", wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExtractedPath(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateExtractedPath(%q) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSymlinkTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		target  string
+		wantErr bool
+	}{
+		{name: "relative target within sandbox", entry: "a/link", target: "../b/real", wantErr: false},
+		{name: "absolute target", entry: "a/link", target: "/etc/passwd", wantErr: true},
+		{name: "target escapes sandbox", entry: "a/link", target: "../../../etc/passwd", wantErr: true},
+		{name: "entry name itself escapes sandbox", entry: "../link", target: "real", wantErr: true},
+		{name: "empty target (e.g. unresolved zip symlink content)", entry: "a/link", target: "", wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSymlinkTarget(tt.entry, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateSymlinkTarget(%q, %q) error = %v, wantErr %v", tt.entry, tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		want   archiveFormat
+		errNil bool
+	}{
+		{name: "zip magic", data: []byte{'P', 'K', 0x03, 0x04, 0x00}, want: archiveFormatZip, errNil: true},
+		{name: "gzip magic", data: []byte{0x1F, 0x8B, 0x08}, want: archiveFormatGzip, errNil: true},
+		{name: "xz magic", data: []byte{0xFD, '7', 'z', 'X', 'Z', 0x00, 0x00}, want: archiveFormatXz, errNil: true},
+		{name: "zstd magic", data: []byte{0x28, 0xB5, 0x2F, 0xFD, 0x00}, want: archiveFormatZstd, errNil: true},
+		{name: "unrecognized", data: []byte{0x00, 0x01, 0x02}, errNil: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectArchiveFormat(tt.data)
+			if (err == nil) != tt.errNil {
+				t.Fatalf("detectArchiveFormat(%x) error = %v, want nil error = %v", tt.data, err, tt.errNil)
+			}
+			if tt.errNil && got != tt.want {
+				t.Fatalf("detectArchiveFormat(%x) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const encAlgAES256GCM = "aes-256-gcm"
+
+const (
+	argon2Time    = 3
+	argon2MemoryK = 64 * 1024 // KiB, i.e. 64 MiB
+	argon2Threads = 2
+	argon2KeyLen  = 32
+
+	saltSize = 16
+)
+
+// deriveOwnerKey stretches an owner passphrase into an AES-256 key with
+// Argon2id, combined with a per-file random salt so two files never share a
+// key even when the owner reuses one passphrase.
+func deriveOwnerKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2MemoryK, argon2Threads, argon2KeyLen)
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveOwnerKey(passphrase, salt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to init AES cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptForOwner encrypts data under a key derived from passphrase and a
+// freshly generated salt, returning the ciphertext alongside the salt and
+// nonce needed to decrypt it later.
+func encryptForOwner(passphrase string, data []byte) (ciphertext, salt, nonce []byte, err error) {
+	salt = make([]byte, saltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	gcm, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	ciphertext = gcm.Seal(nil, nonce, data, nil)
+
+	return ciphertext, salt, nonce, nil
+}
+
+func decryptForOwner(passphrase string, salt, nonce, ciphertext []byte) ([]byte, error) {
+	gcm, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+type encryptedFile struct {
+	id, filePath, checksum, salt, nonce string
+}
+
+func (s *fileService) RotateOwnerKey(owner string, oldPassphrase string, newPassphrase string) error {
+	rows, err := s.db.Query("SELECT id, filepath, checksum, salt, nonce FROM file_index WHERE ownerId = ? AND alg = ?", owner, encAlgAES256GCM)
+	if err != nil {
+		return fmt.Errorf("failed to query encrypted files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []encryptedFile
+
+	for rows.Next() {
+		var f encryptedFile
+		if err := rows.Scan(&f.id, &f.filePath, &f.checksum, &f.salt, &f.nonce); err != nil {
+			return fmt.Errorf("failed to scan file index: %w", err)
+		}
+		files = append(files, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate file index: %w", err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	if err := s.rotateFile(files[0], oldPassphrase, newPassphrase); err != nil {
+		return fmt.Errorf("failed to validate old passphrase: %w", err)
+	}
+
+	go s.rotateOwnerKeyWorker(owner, files[1:], oldPassphrase, newPassphrase)
+
+	return nil
+}
+
+// rotateOwnerKeyWorker re-encrypts the remainder of an owner's files in the
+// background once RotateOwnerKey has confirmed the old passphrase is
+// correct. Best-effort: a file that fails to rotate is left under its old
+// key so it can be retried rather than losing access to it.
+func (s *fileService) rotateOwnerKeyWorker(owner string, files []encryptedFile, oldPassphrase, newPassphrase string) {
+	for _, f := range files {
+		_ = s.rotateFile(f, oldPassphrase, newPassphrase)
+	}
+}
+
+func (s *fileService) rotateFile(f encryptedFile, oldPassphrase, newPassphrase string) error {
+	raw, err := os.ReadFile(f.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.filePath, err)
+	}
+
+	salt, err := hex.DecodeString(f.salt)
+	if err != nil {
+		return fmt.Errorf("failed to decode salt: %w", err)
+	}
+
+	nonce, err := hex.DecodeString(f.nonce)
+	if err != nil {
+		return fmt.Errorf("failed to decode nonce: %w", err)
+	}
+
+	plaintext, err := decryptForOwner(oldPassphrase, salt, nonce, raw)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", f.id, err)
+	}
+
+	sum := sha256.Sum256(plaintext)
+	if hex.EncodeToString(sum[:]) != f.checksum {
+		return fmt.Errorf("checksum mismatch decrypting %s", f.id)
+	}
+
+	ciphertext, newSalt, newNonce, err := encryptForOwner(newPassphrase, plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to re-encrypt %s: %w", f.id, err)
+	}
+
+	// Write the re-encrypted blob to a temp file next to the original and
+	// rename it into place, so a GetFile running concurrently (or a crash
+	// mid-write) always sees either the old, fully-written file or the new
+	// one - never a half-written one.
+	tmpPath := f.filePath + ".rotate.tmp"
+	if err := os.WriteFile(tmpPath, ciphertext, FilePermsDefault); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE file_index SET salt = ?, nonce = ? WHERE id = ?",
+		hex.EncodeToString(newSalt), hex.EncodeToString(newNonce), f.id,
+	); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to update file index for %s: %w", f.id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to commit transaction for %s: %w", f.id, err)
+	}
+
+	// The DB row now names newSalt/newNonce, so the rename must follow the
+	// commit, not precede it: renaming first (and the commit failing) would
+	// leave the new ciphertext on disk paired with the still-old row.
+	if err := os.Rename(tmpPath, f.filePath); err != nil {
+		// The old ciphertext is still in place, so point the row back at the
+		// salt/nonce that decrypt it.
+		s.db.Exec("UPDATE file_index SET salt = ?, nonce = ? WHERE id = ?", f.salt, f.nonce, f.id)
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", f.filePath, err)
+	}
+
+	return nil
+}
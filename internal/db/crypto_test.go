@@ -0,0 +1,68 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEncryptDecryptForOwnerRoundTrip(t *testing.T) {
+	passphrase := "correct horse battery staple"
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatalf("failed to generate salt: %v", err)
+	}
+	data := []byte("the quick brown fox jumps over the lazy dog")
+
+	gcm, err := newAEAD(passphrase, salt)
+	if err != nil {
+		t.Fatalf("newAEAD: %v", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("failed to generate nonce: %v", err)
+	}
+
+	ciphertext := gcm.Seal(nil, nonce, data, nil)
+
+	plaintext, err := decryptForOwner(passphrase, salt, nonce, ciphertext)
+	if err != nil {
+		t.Fatalf("decryptForOwner: %v", err)
+	}
+
+	if !bytes.Equal(plaintext, data) {
+		t.Fatalf("round trip mismatch: got %q, want %q", plaintext, data)
+	}
+}
+
+func TestEncryptForOwnerWrongPassphraseFails(t *testing.T) {
+	data := []byte("super secret apk bytes")
+
+	ciphertext, salt, nonce, err := encryptForOwner("correct-passphrase", data)
+	if err != nil {
+		t.Fatalf("encryptForOwner: %v", err)
+	}
+
+	if _, err := decryptForOwner("wrong-passphrase", salt, nonce, ciphertext); err == nil {
+		t.Fatal("expected decryption with the wrong passphrase to fail, got nil error")
+	}
+}
+
+func TestDeriveOwnerKeyIsDeterministicPerSalt(t *testing.T) {
+	salt := bytes.Repeat([]byte{0x42}, saltSize)
+
+	key1 := deriveOwnerKey("passphrase", salt)
+	key2 := deriveOwnerKey("passphrase", salt)
+
+	if !bytes.Equal(key1, key2) {
+		t.Fatal("deriveOwnerKey produced different keys for the same passphrase and salt")
+	}
+
+	otherSalt := bytes.Repeat([]byte{0x24}, saltSize)
+	key3 := deriveOwnerKey("passphrase", otherSalt)
+
+	if bytes.Equal(key1, key3) {
+		t.Fatal("deriveOwnerKey produced the same key for two different salts")
+	}
+}
@@ -16,13 +16,24 @@ import (
 )
 
 type FileService interface {
-	SaveFile(owner string, accessGroups []string, filename string, fileExtension string, data []byte) (models.FileIndex, error)
+	// passphrase is only consulted when FileServiceConfig.SecureMode is true;
+	// it derives the owner's per-file encryption key and is ignored otherwise.
+	SaveFile(owner string, accessGroups []string, filename string, fileExtension string, data []byte, passphrase string) (models.FileIndex, error)
+	SaveArchive(owner string, accessGroups []string, archiveData []byte, passphrase string) ([]models.FileIndex, error)
 	UpdateFile(owner string, accessGroups []string, fileId string, filename string, data []byte) (models.FileIndex, error)
 
-	GetFile(owner string, fileId string) (models.File, error)
-	ListFiles(owner string) ([]models.FileIndex, error)
+	// GetFile and ListFiles authorize against the caller's identity, not the
+	// file's owner: a file is visible if callerId owns it or callerGroups
+	// intersects the file's access_groups.
+	GetFile(ctx context.Context, callerId string, callerGroups []string, fileId string, passphrase string) (models.File, error)
+	ListFiles(ctx context.Context, callerId string, callerGroups []string) ([]models.FileIndex, error)
 
 	DeleteFile(owner string, fileId string) error
+
+	// RotateOwnerKey re-encrypts owner's files under newPassphrase. It
+	// validates oldPassphrase against the first encrypted file found, then
+	// hands the rest off to a background worker and returns.
+	RotateOwnerKey(owner string, oldPassphrase string, newPassphrase string) error
 }
 
 type fileService struct {
@@ -43,74 +54,323 @@ func NewFileService(config FileServiceConfig, db *sql.DB) *fileService {
 	}
 }
 
-func (s *fileService) SaveFile(owner string, accessGroups []string, filename string, fileExtension string, data []byte) (models.FileIndex, error) {
-	sandBoxRoot := filepath.Join(s.config.Root, owner)
-
-	if err := os.MkdirAll(sandBoxRoot, DirPermsDefault); err != nil {
-		return models.FileIndex{}, fmt.Errorf("failed to create directory: %w", err)
+// pathWithinRoot reports whether path is root itself or a
+// descendant of it. A plain strings.HasPrefix(path, root) check is
+// bypassable by a sibling directory that merely shares root as a string
+// prefix (e.g. root "/var/store/files" and path "/var/store/files-evil/x"),
+// so this compares path against root using filepath.Rel instead.
+func pathWithinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
 	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
 
-	fileExtension = strings.ToLower(strings.TrimSpace(fileExtension))
+// blobPath returns the content-addressed location of the blob for checksum,
+// sharded by its first byte (as hex) so a single directory never ends up
+// with one entry per file on disk.
+func (s *fileService) blobPath(checksum string) string {
+	return filepath.Join(s.config.Root, "objects", checksum[:2], checksum)
+}
 
-	if !s.validateFileExtension(fileExtension) {
-		return models.FileIndex{}, fmt.Errorf("file extension not allowed: %s", fileExtension)
+// saveBlob writes data to its content-addressed path if it isn't already
+// there. Two uploads of the same bytes end up sharing this one file.
+func (s *fileService) saveBlob(checksum string, data []byte) (string, error) {
+	blobPath := s.blobPath(checksum)
+
+	if _, err := os.Stat(blobPath); err == nil {
+		return blobPath, nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to stat blob: %w", err)
 	}
 
-	sha256Sum := sha256.Sum256(data)
-	checksum := hex.EncodeToString(sha256Sum[:])
+	if err := os.MkdirAll(filepath.Dir(blobPath), DirPermsDefault); err != nil {
+		return "", fmt.Errorf("failed to create objects directory: %w", err)
+	}
 
-	cleanFileName := filepath.Base(filename)
+	if err := os.WriteFile(blobPath, data, FilePermsDefault); err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
 
-	fileId := uuid.NewString()
+	return blobPath, nil
+}
+
+// retainBlob bumps blob_refs for checksum, inserting a fresh row the first
+// time it's seen. Called within the same transaction as the file_index
+// insert so a crash between the two can never leave an orphaned ref.
+func retainBlob(tx *sql.Tx, checksum string) error {
+	res, err := tx.Exec("UPDATE blob_refs SET refcount = refcount + 1 WHERE checksum = ?", checksum)
+	if err != nil {
+		return fmt.Errorf("failed to bump blob refcount: %w", err)
+	}
 
-	fileName := fmt.Sprintf("%s-%s.%s", cleanFileName, fileId, fileExtension)
-	absFilePath := filepath.Join(sandBoxRoot, fileName)
-	cleanFilePath := filepath.Clean(absFilePath)
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check blob refcount update: %w", err)
+	}
 
-	if !strings.HasPrefix(cleanFilePath, s.config.Root) {
-		return models.FileIndex{}, fmt.Errorf("file path escapes root: %s", cleanFilePath)
+	if rows == 0 {
+		if _, err := tx.Exec("INSERT INTO blob_refs (checksum, refcount) VALUES (?, 1)", checksum); err != nil {
+			return fmt.Errorf("failed to insert blob refcount: %w", err)
+		}
 	}
 
-	if err := os.WriteFile(absFilePath, data, FilePermsDefault); err != nil {
-		return models.FileIndex{}, fmt.Errorf("failed to write file: %w", err)
+	return nil
+}
+
+// persistFile validates filename's extension, writes data (encrypted under
+// passphrase when SecureMode is on) to storage, and registers the logical
+// file_index + access_groups rows on tx. The caller owns the transaction's
+// lifetime, so SaveFile can commit a single row while SaveArchive commits
+// many as one atomic unit.
+//
+// Content-addressed dedup (blob_refs) only applies to plaintext files: an
+// encrypted blob is unique per upload because of its random salt/nonce, so
+// there's nothing to dedup against and each gets its own file on disk.
+func (s *fileService) persistFile(tx *sql.Tx, owner string, accessGroups []string, filename string, data []byte, passphrase string) (models.FileIndex, error) {
+	fileExtension := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+
+	if !s.validateFileExtension(fileExtension) {
+		return models.FileIndex{}, fmt.Errorf("file extension not allowed: %s", fileExtension)
 	}
 
+	sha256Sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sha256Sum[:])
+
+	fileId := uuid.NewString()
+
 	fileIndex := models.FileIndex{
 		ID:        fileId,
+		Filename:  filepath.Base(filename),
 		Size:      int64(len(data)),
 		Owner:     owner,
-		FilePath:  cleanFilePath,
 		Checksum:  checksum,
 		CreatedAt: time.Now().Unix(),
 	}
 
-	for group := range accessGroups {
-		_, err := s.db.Exec("INSERT INTO access_groups (fileId, groupId) VALUES (?, ?)", fileId, group)
+	if s.config.SecureMode {
+		ciphertext, salt, nonce, err := encryptForOwner(passphrase, data)
+		if err != nil {
+			return models.FileIndex{}, fmt.Errorf("failed to encrypt file: %w", err)
+		}
 
+		encPath := filepath.Join(s.config.Root, owner, fileId+".enc")
+		if !pathWithinRoot(s.config.Root, encPath) {
+			return models.FileIndex{}, fmt.Errorf("file path escapes root: %s", encPath)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(encPath), DirPermsDefault); err != nil {
+			return models.FileIndex{}, fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.WriteFile(encPath, ciphertext, FilePermsDefault); err != nil {
+			return models.FileIndex{}, fmt.Errorf("failed to write encrypted file: %w", err)
+		}
+
+		fileIndex.FilePath = encPath
+		fileIndex.Salt = hex.EncodeToString(salt)
+		fileIndex.Nonce = hex.EncodeToString(nonce)
+		fileIndex.Alg = encAlgAES256GCM
+	} else {
+		blobPath, err := s.saveBlob(checksum, data)
 		if err != nil {
-			return models.FileIndex{}, fmt.Errorf("failed to insert access group: %w", err)
+			return models.FileIndex{}, err
+		}
+
+		if err := retainBlob(tx, checksum); err != nil {
+			return models.FileIndex{}, err
 		}
+
+		fileIndex.FilePath = blobPath
 	}
 
-	if _, err := s.db.Exec(
-		"INSERT INTO file_index (id, ownerId, size, filepath, createdAt, checksum) VALUES (?, ?, ?, ?, ?, ?)",
-		fileIndex.ID, fileIndex.Owner, fileIndex.Size, fileIndex.FilePath, fileIndex.CreatedAt, fileIndex.Checksum,
+	if _, err := tx.Exec(
+		"INSERT INTO file_index (id, ownerId, filename, size, filepath, createdAt, checksum, salt, nonce, alg) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		fileIndex.ID, fileIndex.Owner, fileIndex.Filename, fileIndex.Size, fileIndex.FilePath, fileIndex.CreatedAt, fileIndex.Checksum,
+		fileIndex.Salt, fileIndex.Nonce, fileIndex.Alg,
 	); err != nil {
 		return models.FileIndex{}, fmt.Errorf("failed to insert file index: %w", err)
 	}
 
+	for _, group := range accessGroups {
+		if _, err := tx.Exec("INSERT INTO access_groups (fileId, groupId) VALUES (?, ?)", fileIndex.ID, group); err != nil {
+			return models.FileIndex{}, fmt.Errorf("failed to insert access group: %w", err)
+		}
+	}
+
 	return fileIndex, nil
 }
 
-func (s *fileService) GetFile(ctx context.Context, owner string, fileId string) (models.File, error) {
+func (s *fileService) SaveFile(owner string, accessGroups []string, filename string, fileExtension string, data []byte, passphrase string) (models.FileIndex, error) {
+	cleanFileName := fmt.Sprintf("%s.%s", filepath.Base(filename), strings.ToLower(strings.TrimSpace(fileExtension)))
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.FileIndex{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileIndex, err := s.persistFile(tx, owner, accessGroups, cleanFileName, data, passphrase)
+	if err != nil {
+		return models.FileIndex{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.FileIndex{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return fileIndex, nil
+}
+
+// UpdateFile replaces the content of an existing file_index row owned by
+// owner with data, re-running it through the same content-addressing and
+// extension checks as SaveFile, and replaces its access_groups with
+// accessGroups. The old blob's refcount is released after the new one is
+// persisted, so a file_index row is never left pointing at nothing.
+//
+// UpdateFile has no passphrase parameter, so it cannot be used on files
+// stored under FileServiceConfig.SecureMode; use SaveFile (delete + re-save)
+// for those instead.
+func (s *fileService) UpdateFile(owner string, accessGroups []string, fileId string, filename string, data []byte) (models.FileIndex, error) {
+	if s.config.SecureMode {
+		return models.FileIndex{}, fmt.Errorf("UpdateFile does not support SecureMode files: re-save %s instead", fileId)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.FileIndex{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldChecksum, oldFilePath string
+	err = tx.QueryRow("SELECT checksum, filepath FROM file_index WHERE id = ? AND ownerId = ?", fileId, owner).Scan(&oldChecksum, &oldFilePath)
+	if err == sql.ErrNoRows {
+		return models.FileIndex{}, fmt.Errorf("file not found")
+	} else if err != nil {
+		return models.FileIndex{}, fmt.Errorf("failed to query file index: %w", err)
+	}
+
+	fileExtension := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if !s.validateFileExtension(fileExtension) {
+		return models.FileIndex{}, fmt.Errorf("file extension not allowed: %s", fileExtension)
+	}
+
+	sha256Sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sha256Sum[:])
+
+	blobPath, err := s.saveBlob(checksum, data)
+	if err != nil {
+		return models.FileIndex{}, err
+	}
+
+	if err := retainBlob(tx, checksum); err != nil {
+		return models.FileIndex{}, err
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE file_index SET filename = ?, size = ?, filepath = ?, checksum = ?, salt = '', nonce = '', alg = '' WHERE id = ?",
+		filepath.Base(filename), int64(len(data)), blobPath, checksum, fileId,
+	); err != nil {
+		return models.FileIndex{}, fmt.Errorf("failed to update file index: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM access_groups WHERE fileId = ?", fileId); err != nil {
+		return models.FileIndex{}, fmt.Errorf("failed to clear access groups: %w", err)
+	}
+
+	for _, group := range accessGroups {
+		if _, err := tx.Exec("INSERT INTO access_groups (fileId, groupId) VALUES (?, ?)", fileId, group); err != nil {
+			return models.FileIndex{}, fmt.Errorf("failed to insert access group: %w", err)
+		}
+	}
+
+	unlinkOldBlob, err := releaseBlob(tx, oldChecksum)
+	if err != nil {
+		return models.FileIndex{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.FileIndex{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if unlinkOldBlob && oldFilePath != blobPath {
+		if err := os.Remove(oldFilePath); err != nil && !os.IsNotExist(err) {
+			return models.FileIndex{}, fmt.Errorf("failed to unlink old blob: %w", err)
+		}
+	}
+
+	return models.FileIndex{
+		ID:       fileId,
+		Filename: filepath.Base(filename),
+		Size:     int64(len(data)),
+		Owner:    owner,
+		FilePath: blobPath,
+		Checksum: checksum,
+	}, nil
+}
+
+// releaseBlob drops checksum's refcount by one and deletes the blob_refs row
+// once it reaches zero; DeleteFile and UpdateFile both release a file's blob
+// through it. It reports whether the
+// refcount hit zero, i.e. whether the caller should unlink the blob once the
+// transaction commits.
+func releaseBlob(tx *sql.Tx, checksum string) (bool, error) {
+	if _, err := tx.Exec("UPDATE blob_refs SET refcount = refcount - 1 WHERE checksum = ?", checksum); err != nil {
+		return false, fmt.Errorf("failed to decrement blob refcount: %w", err)
+	}
+
+	var refcount int
+	if err := tx.QueryRow("SELECT refcount FROM blob_refs WHERE checksum = ?", checksum).Scan(&refcount); err != nil {
+		return false, fmt.Errorf("failed to read blob refcount: %w", err)
+	}
+
+	if refcount > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec("DELETE FROM blob_refs WHERE checksum = ?", checksum); err != nil {
+		return false, fmt.Errorf("failed to delete blob refcount: %w", err)
+	}
+
+	return true, nil
+}
+
+// accessPredicate builds the "fi.ownerId = ? [OR ag.groupId IN (...)]" clause
+// shared by GetFile and ListFiles, along with the args it binds.
+func accessPredicate(callerId string, callerGroups []string) (string, []interface{}) {
+	predicate := "fi.ownerId = ?"
+	args := []interface{}{callerId}
+
+	if len(callerGroups) == 0 {
+		return predicate, args
+	}
+
+	placeholders := make([]string, len(callerGroups))
+	for i, group := range callerGroups {
+		placeholders[i] = "?"
+		args = append(args, group)
+	}
+
+	predicate += fmt.Sprintf(" OR ag.groupId IN (%s)", strings.Join(placeholders, ", "))
+
+	return predicate, args
+}
+
+func (s *fileService) GetFile(ctx context.Context, callerId string, callerGroups []string, fileId string, passphrase string) (models.File, error) {
+	predicate, args := accessPredicate(callerId, callerGroups)
+
+	query := fmt.Sprintf(
+		"SELECT DISTINCT fi.id, fi.ownerId, fi.filename, fi.size, fi.filepath, fi.createdAt, fi.checksum, fi.salt, fi.nonce, fi.alg "+
+			"FROM file_index fi LEFT JOIN access_groups ag ON ag.fileId = fi.id "+
+			"WHERE fi.id = ? AND (%s)",
+		predicate,
+	)
+
 	var metadata models.FileIndex
 
-	fileErr := s.db.QueryRowContext(
-		ctx,
-		"Select id, ownerId, size, filepath, createdAt, checksum, accessGroups FROM file_index WHERE id = ? AND ownerId = ?",
-		fileId, owner,
-	).Scan(
-		&metadata.ID, &metadata.Owner, &metadata.Size, &metadata.FilePath, &metadata.CreatedAt, &metadata.Checksum, &metadata.AccessGroups,
+	fileErr := s.db.QueryRowContext(ctx, query, append([]interface{}{fileId}, args...)...).Scan(
+		&metadata.ID, &metadata.Owner, &metadata.Filename, &metadata.Size, &metadata.FilePath, &metadata.CreatedAt, &metadata.Checksum,
+		&metadata.Salt, &metadata.Nonce, &metadata.Alg,
 	)
 
 	if fileErr == sql.ErrNoRows {
@@ -121,16 +381,34 @@ func (s *fileService) GetFile(ctx context.Context, owner string, fileId string)
 
 	filePath := metadata.FilePath
 
-	if !strings.HasPrefix(filePath, s.config.Root) {
+	if !pathWithinRoot(s.config.Root, filePath) {
 		return models.File{}, fmt.Errorf("file path escapes root: %s", filePath)
 	}
 
-	data, err := os.ReadFile(filePath)
+	raw, err := os.ReadFile(filePath)
 
 	if err != nil {
 		return models.File{}, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	data := raw
+
+	if metadata.Alg != "" {
+		salt, err := hex.DecodeString(metadata.Salt)
+		if err != nil {
+			return models.File{}, fmt.Errorf("failed to decode salt: %w", err)
+		}
+		nonce, err := hex.DecodeString(metadata.Nonce)
+		if err != nil {
+			return models.File{}, fmt.Errorf("failed to decode nonce: %w", err)
+		}
+
+		data, err = decryptForOwner(passphrase, salt, nonce, raw)
+		if err != nil {
+			return models.File{}, fmt.Errorf("failed to decrypt file: %w", err)
+		}
+	}
+
 	fileHash := sha256.Sum256(data)
 	checkSum := hex.EncodeToString(fileHash[:])
 
@@ -144,6 +422,97 @@ func (s *fileService) GetFile(ctx context.Context, owner string, fileId string)
 	}, nil
 }
 
+func (s *fileService) ListFiles(ctx context.Context, callerId string, callerGroups []string) ([]models.FileIndex, error) {
+	predicate, args := accessPredicate(callerId, callerGroups)
+
+	query := fmt.Sprintf(
+		"SELECT DISTINCT fi.id, fi.ownerId, fi.filename, fi.size, fi.filepath, fi.createdAt, fi.checksum, fi.salt, fi.nonce, fi.alg "+
+			"FROM file_index fi LEFT JOIN access_groups ag ON ag.fileId = fi.id "+
+			"WHERE %s",
+		predicate,
+	)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file index: %w", err)
+	}
+	defer rows.Close()
+
+	var files []models.FileIndex
+
+	for rows.Next() {
+		var metadata models.FileIndex
+
+		if err := rows.Scan(
+			&metadata.ID, &metadata.Owner, &metadata.Filename, &metadata.Size, &metadata.FilePath, &metadata.CreatedAt, &metadata.Checksum,
+			&metadata.Salt, &metadata.Nonce, &metadata.Alg,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan file index: %w", err)
+		}
+
+		files = append(files, metadata)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate file index: %w", err)
+	}
+
+	return files, nil
+}
+
+func (s *fileService) DeleteFile(owner string, fileId string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var checksum, filePath, alg string
+
+	err = tx.QueryRow("SELECT checksum, filepath, alg FROM file_index WHERE id = ? AND ownerId = ?", fileId, owner).Scan(&checksum, &filePath, &alg)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("file not found")
+	} else if err != nil {
+		return fmt.Errorf("failed to query file index: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM file_index WHERE id = ?", fileId); err != nil {
+		return fmt.Errorf("failed to delete file index: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM access_groups WHERE fileId = ?", fileId); err != nil {
+		return fmt.Errorf("failed to delete access groups: %w", err)
+	}
+
+	// Encrypted files aren't content-addressed (each has a unique random
+	// salt/nonce), so they have no blob_refs row to decrement - the file on
+	// disk belongs solely to this file_index entry and is always unlinked.
+	unlinkPath := filePath
+
+	if alg == "" {
+		refcountHitZero, err := releaseBlob(tx, checksum)
+		if err != nil {
+			return err
+		}
+
+		if !refcountHitZero {
+			unlinkPath = ""
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if unlinkPath != "" {
+		if err := os.Remove(unlinkPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to unlink blob: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (s *fileService) validateFileExtension(fileExtension string) bool {
 	for _, extension := range s.config.AllowedFileExtensions {
 		if extension == fileExtension {
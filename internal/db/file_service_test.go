@@ -0,0 +1,181 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestAccessPredicate(t *testing.T) {
+	t.Run("no groups falls back to owner-only", func(t *testing.T) {
+		predicate, args := accessPredicate("alice", nil)
+
+		if predicate != "fi.ownerId = ?" {
+			t.Fatalf("predicate = %q, want %q", predicate, "fi.ownerId = ?")
+		}
+		if len(args) != 1 || args[0] != "alice" {
+			t.Fatalf("args = %v, want [alice]", args)
+		}
+	})
+
+	t.Run("groups are OR'd in with matching placeholders", func(t *testing.T) {
+		predicate, args := accessPredicate("alice", []string{"eng", "qa"})
+
+		if !strings.HasPrefix(predicate, "fi.ownerId = ? OR ag.groupId IN (") {
+			t.Fatalf("predicate = %q, missing owner/group OR clause", predicate)
+		}
+		if want := []interface{}{"alice", "eng", "qa"}; !equalArgs(args, want) {
+			t.Fatalf("args = %v, want %v", args, want)
+		}
+	})
+}
+
+func TestPathWithinRoot(t *testing.T) {
+	const root = "/var/adbstore/files"
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{name: "root itself", path: "/var/adbstore/files", want: true},
+		{name: "direct child", path: "/var/adbstore/files/owner/file.enc", want: true},
+		{name: "nested descendant", path: "/var/adbstore/files/objects/ab/abcdef", want: true},
+		{name: "sibling directory sharing root as a string prefix", path: "/var/adbstore/files-evil/x", want: false},
+		{name: "parent directory", path: "/var/adbstore", want: false},
+		{name: "unrelated path", path: "/etc/passwd", want: false},
+		{name: "traversal back out via ..", path: "/var/adbstore/files/../files-evil/x", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathWithinRoot(root, tt.path); got != tt.want {
+				t.Fatalf("pathWithinRoot(%q, %q) = %v, want %v", root, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func equalArgs(got, want []interface{}) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newTestDB returns an in-memory database with just the blob_refs table
+// retainBlob/releaseBlob operate on.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec("CREATE TABLE blob_refs (checksum TEXT PRIMARY KEY, refcount INTEGER NOT NULL)"); err != nil {
+		t.Fatalf("failed to create blob_refs: %v", err)
+	}
+
+	return conn
+}
+
+func TestRetainBlobInsertsThenIncrements(t *testing.T) {
+	conn := newTestDB(t)
+	const checksum = "deadbeef"
+
+	withTx(t, conn, func(tx *sql.Tx) {
+		if err := retainBlob(tx, checksum); err != nil {
+			t.Fatalf("retainBlob (first): %v", err)
+		}
+	})
+	if got := refcountOf(t, conn, checksum); got != 1 {
+		t.Fatalf("refcount after first retain = %d, want 1", got)
+	}
+
+	withTx(t, conn, func(tx *sql.Tx) {
+		if err := retainBlob(tx, checksum); err != nil {
+			t.Fatalf("retainBlob (second): %v", err)
+		}
+	})
+	if got := refcountOf(t, conn, checksum); got != 2 {
+		t.Fatalf("refcount after second retain = %d, want 2", got)
+	}
+}
+
+func TestReleaseBlobDecrementsAndSweepsAtZero(t *testing.T) {
+	conn := newTestDB(t)
+	const checksum = "cafef00d"
+
+	withTx(t, conn, func(tx *sql.Tx) {
+		_ = retainBlob(tx, checksum)
+		_ = retainBlob(tx, checksum)
+	})
+
+	var hitZero bool
+	withTx(t, conn, func(tx *sql.Tx) {
+		var err error
+		hitZero, err = releaseBlob(tx, checksum)
+		if err != nil {
+			t.Fatalf("releaseBlob (first): %v", err)
+		}
+	})
+	if hitZero {
+		t.Fatal("releaseBlob reported refcount hit zero after dropping from 2 to 1")
+	}
+	if got := refcountOf(t, conn, checksum); got != 1 {
+		t.Fatalf("refcount after first release = %d, want 1", got)
+	}
+
+	withTx(t, conn, func(tx *sql.Tx) {
+		var err error
+		hitZero, err = releaseBlob(tx, checksum)
+		if err != nil {
+			t.Fatalf("releaseBlob (second): %v", err)
+		}
+	})
+	if !hitZero {
+		t.Fatal("releaseBlob did not report refcount hit zero after dropping from 1 to 0")
+	}
+
+	var count int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM blob_refs WHERE checksum = ?", checksum).Scan(&count); err != nil {
+		t.Fatalf("failed to check blob_refs row: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("blob_refs row for %s still present after refcount hit zero", checksum)
+	}
+}
+
+func withTx(t *testing.T, conn *sql.DB, fn func(tx *sql.Tx)) {
+	t.Helper()
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+
+	fn(tx)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+}
+
+func refcountOf(t *testing.T, conn *sql.DB, checksum string) int {
+	t.Helper()
+
+	var refcount int
+	if err := conn.QueryRow("SELECT refcount FROM blob_refs WHERE checksum = ?", checksum).Scan(&refcount); err != nil {
+		t.Fatalf("failed to read refcount for %s: %v", checksum, err)
+	}
+	return refcount
+}
@@ -39,3 +39,39 @@ type DeviceStateChange struct {
 	NewState  DeviceState `json:"new_state"`
 	Timestamp time.Time   `json:"timestamp"`
 }
+
+type TransferEventType string
+
+const (
+	TransferEventFileStarted TransferEventType = "file_started"
+	TransferEventProgress    TransferEventType = "progress"
+	TransferEventFileDone    TransferEventType = "file_done"
+	TransferEventComplete    TransferEventType = "complete"
+)
+
+// TransferEvent reports the progress of a Push or Pull as it happens. A
+// caller reads these off the channel returned by ADBClient.Push/Pull
+// instead of blocking until the whole transfer finishes.
+type TransferEvent struct {
+	Type             TransferEventType
+	File             string // device-side path of the file this event refers to
+	BytesTransferred int64
+	TotalBytes       int64
+	ETA              time.Duration
+	Err              error
+}
+
+// TransferOptions controls how ADBClient.Push and Pull move files.
+type TransferOptions struct {
+	Recursive      bool // Push only: when the local source is a directory, push it and its contents; Pull always transfers a single file
+	VerifyChecksum bool // recompute a SHA-256 on the device after the transfer and fail on mismatch
+}
+
+// InstallOptions controls the `pm install` flags ADBClient.InstallFromStore
+// passes through to the device.
+type InstallOptions struct {
+	Replace          bool // -r, replace an existing installation
+	AllowDowngrade   bool // -d, allow a version-code downgrade
+	GrantPermissions bool // -g, grant all runtime permissions
+	User             *int // --user <id>, omitted entirely when nil
+}
@@ -2,11 +2,18 @@ package models
 
 type FileIndex struct {
 	ID        string `json:"id"`
+	Filename  string `json:"filename"`
 	Size      int64  `json:"size"`
 	Owner     string `json:"owner"`
-	FilePath  string `json:"file_path"`
-	Checksum  string `json:"checksum"`
+	FilePath  string `json:"file_path"` // on-disk file backing this entry: the shared content-addressed blob, or a per-file .enc file when Alg is set
+	Checksum  string `json:"checksum"`  // SHA-256 of the plaintext, even when Alg is set
 	CreatedAt int64  `json:"created_at"`
+
+	// Salt, Nonce and Alg are only populated when this entry is encrypted at
+	// rest (FileServiceConfig.SecureMode). Alg is empty for plaintext files.
+	Salt  string `json:"salt,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	Alg   string `json:"alg,omitempty"`
 }
 
 type File struct {